@@ -0,0 +1,87 @@
+# frozen_string_literal: true
+
+require 'colorize'
+
+module Diff
+  # Line diff based on the longest common subsequence of both texts. Removed
+  # lines start with "-", added lines with "+" and unchanged lines with a space.
+  def self.diff(old_text, new_text)
+    old_lines = old_text.lines.map(&:chomp)
+    new_lines = new_text.lines.map(&:chomp)
+
+    lengths = Array.new(old_lines.length + 1) { Array.new(new_lines.length + 1, 0) }
+    (old_lines.length - 1).downto(0) do |i|
+      (new_lines.length - 1).downto(0) do |j|
+        lengths[i][j] = if old_lines[i] == new_lines[j]
+                          lengths[i + 1][j + 1] + 1
+                        else
+                          [lengths[i + 1][j], lengths[i][j + 1]].max
+                        end
+      end
+    end
+
+    result = []
+    i = j = 0
+    while i < old_lines.length && j < new_lines.length
+      if old_lines[i] == new_lines[j]
+        result << " #{old_lines[i]}"
+        i += 1
+        j += 1
+      elsif lengths[i + 1][j] >= lengths[i][j + 1]
+        result << "-#{old_lines[i]}"
+        i += 1
+      else
+        result << "+#{new_lines[j]}"
+        j += 1
+      end
+    end
+    old_lines[i..].each { |line| result << "-#{line}" }
+    new_lines[j..].each { |line| result << "+#{line}" }
+
+    result.join("\n")
+  end
+
+  # Only the changed lines and up to `context` unchanged lines around them,
+  # in hunks with "@@ -start,count +start,count @@" headers like diff -u.
+  def self.unified_diff(old_text, new_text, context = 3)
+    lines = self.diff(old_text, new_text).lines(chomp: true)
+    changed = lines.each_index.reject { |index| lines[index].start_with?(' ') }
+
+    # Changes whose context overlaps or touches share a hunk.
+    ranges = []
+    changed.each do |index|
+      first = [index - context, 0].max
+      last = [index + context, lines.length - 1].min
+      if ranges.any? && first <= ranges.last[1] + 1
+        ranges.last[1] = last
+      else
+        ranges << [first, last]
+      end
+    end
+
+    ranges.map do |first, last|
+      hunk = lines[first..last]
+      old_start, old_count = self.hunk_range(lines[0...first], hunk, '+')
+      new_start, new_count = self.hunk_range(lines[0...first], hunk, '-')
+      ["@@ -#{old_start},#{old_count} +#{new_start},#{new_count} @@", *hunk].join("\n")
+    end.join("\n")
+  end
+
+  # An empty range starts at the line before it, as in diff -u.
+  def self.hunk_range(lines_before, hunk, other_side)
+    start = lines_before.count { |line| !line.start_with?(other_side) } + 1
+    count = hunk.count { |line| !line.start_with?(other_side) }
+    [count.zero? ? start - 1 : start, count]
+  end
+
+  def self.colorize(diff)
+    diff.lines(chomp: true).map do |line|
+      case line[0]
+      when '@' then line.colorize(:color => :cyan)
+      when '+' then line.colorize(:color => :green)
+      when '-' then line.colorize(:color => :red)
+      else line
+      end
+    end.join("\n")
+  end
+end
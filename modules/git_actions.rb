@@ -1,12 +1,102 @@
 # frozen_string_literal: true
 
+require 'open3'
+
 module GitActions
+  REPO_ACCOUNT_FILE = '.multigit'
+
+  # HTTPS, scp-style and ssh:// GitHub remotes, optionally using a multigit
+  # host alias (github.com-<account>).
+  GITHUB_REMOTE_REGEX = %r{\A(?:https://(?:[^@/]+@)?github\.com/|(?:ssh://)?git@github\.com(?:-(?<account>[^/:]+))?[:/])(?<path>[^/]+/[^/]+?)(?:\.git)?/?\z}
+
+  def self.repo?(dir = Dir.pwd)
+    Dir.exist?(File.join(dir, '.git'))
+  end
+
+  def self.repo_root
+    stdout, status = Open3.capture2('git', 'rev-parse', '--show-toplevel', err: File::NULL)
+    status.success? ? stdout.chomp : nil
+  rescue SystemCallError
+    nil
+  end
+
+  # The first line of .multigit at the repository root names its account.
+  # Returns nil when the file doesn't exist.
+  def self.read_repo_account(dir)
+    path = File.join(dir, REPO_ACCOUNT_FILE)
+    return nil unless File.file?(path)
+
+    File.foreach(path).first.to_s.strip
+  end
+
+  def self.init_repo
+    system('git', 'init')
+  end
+
+  # Returns nil when the key is not set. Without global or local, the value
+  # git would use is returned, whichever file it comes from.
+  def self.get_config(key, global: false, local: false)
+    command = ['git', 'config']
+    command << '--global' if global
+    command << '--local' if local
+    stdout, status = Open3.capture2(*command, '--get', key, err: File::NULL)
+    status.success? ? stdout.chomp : nil
+  rescue SystemCallError
+    nil
+  end
+
+  def self.set_config(key, value)
+    system('git', 'config', key, value)
+  end
+
+  def self.get_remote_url(remote = 'origin')
+    stdout, status = Open3.capture2('git', 'remote', 'get-url', remote, err: File::NULL)
+    status.success? ? stdout.chomp : nil
+  end
+
+  # Returns [account, "owner/repo"], with a nil account for plain github.com
+  # remotes, or nil when the URL doesn't point at GitHub.
+  def self.parse_github_remote(url)
+    match = url.to_s.match(GITHUB_REMOTE_REGEX)
+    match && [match[:account], match[:path]]
+  end
+
+  def self.account_remote_url(account_name, path)
+    "git@github.com-#{account_name}:#{path}.git"
+  end
 
-  def self.getAccount(account_name)
-    account = Account.find_by(name: account_name)
-    if account.nil?
-      raise ArgumentError, @localization.get_message("system.account_not_found")
+  def self.set_remote_url(url, remote = 'origin')
+    system(*self.remote_url_command(url, remote))
+  end
+
+  def self.remote_url_command(url, remote = 'origin')
+    if system('git', 'remote', 'get-url', remote, out: File::NULL, err: File::NULL)
+      ['git', 'remote', 'set-url', remote, url]
+    else
+      ['git', 'remote', 'add', remote, url]
     end
-    account
+  end
+
+  # The commands 'multigit use' runs, so they can be printed instead. With a
+  # signing_key (a .pub path) commits are signed with that SSH key.
+  def self.use_commands(name, email, url, remote = 'origin', signing_key: nil, allowed_signers_file: nil)
+    commands = [
+      ['git', 'config', 'user.name', name],
+      ['git', 'config', 'user.email', email],
+      self.remote_url_command(url, remote)
+    ]
+    return commands unless signing_key
+
+    commands + [
+      ['git', 'config', 'gpg.format', 'ssh'],
+      ['git', 'config', 'user.signingkey', signing_key],
+      ['git', 'config', 'commit.gpgsign', 'true'],
+      ['git', 'config', 'gpg.ssh.allowedSignersFile', allowed_signers_file]
+    ]
+  end
+
+  # Stops at the first command that fails.
+  def self.run_commands(commands)
+    commands.all? { |command| system(*command) }
   end
 end
@@ -0,0 +1,57 @@
+# frozen_string_literal: true
+
+require 'json'
+require 'net/http'
+
+module GitHubAPI
+  class << self
+    # Can point at a local server, e.g. for GitHub Enterprise or tests.
+    attr_writer :api_url
+
+    def api_url
+      @api_url || 'https://api.github.com'
+    end
+  end
+
+  # Returns :created, :duplicate (the key is already on an account),
+  # :unauthorized or :failed.
+  def self.upload_public_key(title, public_key, token)
+    response = self.request(Net::HTTP::Post, '/user/keys', token, { title: title, key: public_key })
+
+    case response
+    when Net::HTTPCreated then :created
+    when Net::HTTPUnauthorized then :unauthorized
+    when Net::HTTPUnprocessableEntity then :duplicate
+    else :failed
+    end
+  rescue SystemCallError, SocketError, Net::OpenTimeout, Net::ReadTimeout
+    :failed
+  end
+
+  # Returns [:ok, keys] where each key has "id", "key" and "title", or
+  # [:unauthorized, []] / [:failed, []].
+  def self.list_public_keys(token)
+    response = self.request(Net::HTTP::Get, '/user/keys?per_page=100', token)
+
+    case response
+    when Net::HTTPOK then [:ok, JSON.parse(response.body)]
+    when Net::HTTPUnauthorized then [:unauthorized, []]
+    else [:failed, []]
+    end
+  rescue SystemCallError, SocketError, Net::OpenTimeout, Net::ReadTimeout, JSON::ParserError
+    [:failed, []]
+  end
+
+  def self.request(request_class, path, token, body = nil)
+    uri = URI("#{self.api_url}#{path}")
+    request = request_class.new(uri)
+    request['Accept'] = 'application/vnd.github+json'
+    request['Authorization'] = "Bearer #{token}"
+    if body
+      request['Content-Type'] = 'application/json'
+      request.body = JSON.generate(body)
+    end
+
+    Net::HTTP.start(uri.host, uri.port, use_ssl: uri.scheme == 'https') { |http| http.request(request) }
+  end
+end
@@ -2,7 +2,7 @@ require_relative 'localization'
 require_relative 'validation'
 
 module InputManager
-  def self.get_valid_input(message_key, validation_method)
+  def self.get_valid_input(message_key, validation_method, error_key)
     loop do
       puts Localization.get_message(message_key)
       input = STDIN.gets.chomp
@@ -10,7 +10,28 @@ module InputManager
       return input if send(validation_method, input)
       return nil if input.downcase == 'exit' || input.downcase == 'quit'
 
-      puts Localization.get_message("error.#{validation_method}")
+      puts Localization.get_message(error_key)
+    end
+  end
+
+  # Only asks on a terminal, so scripts get nil instead of a hanging prompt.
+  def self.select_account(accounts, input = STDIN)
+    return nil if accounts.empty? || !input.tty?
+
+    accounts.each_with_index do |account_name, index|
+      puts "#{index + 1}) #{account_name}"
+    end
+
+    loop do
+      puts Localization.get_message("input.select_account")
+      answer = input.gets&.chomp
+
+      return nil if answer.nil? || answer.downcase == 'exit' || answer.downcase == 'quit'
+
+      index = answer.to_i
+      return accounts[index - 1] if index.between?(1, accounts.length)
+
+      puts Localization.get_message("error.invalid_selection")
     end
   end
 
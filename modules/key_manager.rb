@@ -1,78 +1,452 @@
 require 'tempfile'
 require 'open3'
+require 'did_you_mean'
+require_relative 'validation'
+require_relative 'diff'
+require_relative 'system_tools'
 
 class KeyManager
+  # Marks the lines of a disabled entry, so they can't be mistaken for the
+  # user's own comments around it.
+  DISABLED_PREFIX = '# multigit-disabled: '
+  SSH_SCOPES = %w[user include].freeze
+  INCLUDE_FILE = File.join('config.d', 'multigit')
+
+  class << self
+    attr_writer :ssh_dir
+    attr_accessor :verbose
+
+    # Keys and the SSH config live in ~/.ssh unless --ssh-dir points elsewhere.
+    def ssh_dir
+      @ssh_dir || File.join(Dir.home, '.ssh')
+    end
+
+    # 'user' edits ~/.ssh/config directly. 'include' keeps multigit's entries
+    # in ~/.ssh/config.d/multigit, which the main config Includes. The Include
+    # line is the setting, so every command agrees on the scope.
+    def ssh_scope
+      main_content = File.exist?(user_ssh_config_path) ? File.read(user_ssh_config_path) : ''
+      main_content.lines.any? { |line| include_file_line?(line) } ? 'include' : 'user'
+    end
+
+    def user_ssh_config_path
+      File.join(ssh_dir, 'config')
+    end
+
+    def include_file_path
+      File.join(ssh_dir, INCLUDE_FILE)
+    end
+
+    def ssh_config_path
+      ssh_scope == 'include' ? include_file_path : user_ssh_config_path
+    end
+
+    def ssh_config_backup_path
+      "#{ssh_config_path}.multigit.bak"
+    end
+  end
+
   def initialize(config, localization)
     @config = config
     @localization = localization
   end
 
   def self.create_ssh_key(account_name,account_email, add_passphrase = false)
-    command = "ssh-keygen -t ed25519 -C '#{account_email}' -f #{self.ssh_key_path(account_name)}"
-    if add_passphrase
-      command += " -N ''"
-    end
-    Open3.popen3(command) do |stdin, stdout, stderr, wait_thr|
-      error_message = stderr.read
-      if error_message.empty?
-        puts "SSH key successfully generated."
-        return true
-      else
-        warn "Error generating SSH key: #{error_message}"
-        return false
-      end
+    command = ['ssh-keygen', '-t', 'ed25519', '-C', account_email, '-f', self.ssh_key_path(account_name)]
+    # Without -N, ssh-keygen asks for the passphrase on the terminal, so it
+    # runs with our stdin instead of a pipe.
+    unless add_passphrase
+      command += ['-N', '']
+    end
+    FileUtils.mkdir_p(self.ssh_dir, mode: 0o700)
+    if system(*command)
+      puts "SSH key successfully generated."
+      return true
     end
+
+    # Don't leave a half-written key behind that would block a retry.
+    FileUtils.rm_f(self.managed_artifacts(account_name)[:key_files])
+    warn "Error generating SSH key: ssh-keygen failed"
+    false
   end
-  def self.add_ssh_config_entry(account_name)
-    key_path = self.ssh_key_path(account_name)
+
+  # ssh-keygen asks for the old passphrase before the new one and keeps the
+  # key's permissions. An empty new passphrase removes the encryption.
+  def self.change_passphrase(account_name)
+    system('ssh-keygen', '-p', '-f', self.ssh_key_path(account_name))
+  end
+
+  # Without ssh_options, the options the account was created with are used.
+  def self.add_ssh_config_entry(account_name, ssh_options = nil)
+    ssh_options ||= self.ssh_options(account_name)
     begin
-      ssh_config_content = File.read(SSH_CONFIG_PATH)
+      ssh_config_content = File.exist?(self.ssh_config_path) ? File.read(self.ssh_config_path) : ''
     rescue => e
       warn "SSH konfigürasyon dosyası okunamadı: #{e.message}"
       return false
     end
 
+    config_entry = self.render_ssh_config_entry(account_name, ssh_options)
+
+    blocks = self.parse_ssh_config(ssh_config_content)
+    existing_block = blocks.find { |block| block[:account] == account_name }
+
+    updated_content = if existing_block
+                        existing_block[:lines] = config_entry.lines + self.split_config_block(existing_block)[1]
+                        blocks.flat_map { |block| block[:lines] }.join
+                      else
+                        self.append_config_entry(ssh_config_content, config_entry)
+                      end
+
+    begin
+      self.write_ssh_config(updated_content)
+      self.save_ssh_options(account_name, ssh_options)
+    rescue => e
+      warn "SSH konfigürasyon dosyası güncellenirken hata oluştu: #{e.message}"
+      return false
+    end
+
+    true
+  end
+
+  # Like add_ssh_config_entry, renders the account's saved options by default.
+  def self.render_ssh_config_entry(account_name, ssh_options = nil)
+    ssh_options ||= self.ssh_options(account_name)
     config_entry = <<~CONFIG
-      Host github.com-#{account_name}
+      Host #{self.managed_artifacts(account_name)[:host_alias]}
       HostName github.com
       User git
-      IdentityFile #{key_path}
+      IdentityFile #{self.ssh_config_argument(self.ssh_key_path(account_name))}
     CONFIG
+    ssh_options.each { |key, value| config_entry += "#{key} #{value}\n" }
+    config_entry
+  end
 
-    # Check current input
-    existing_entry_regex = /Host github.com-#{account_name}\nHostName github.com\nUser git\nIdentityFile #{key_path}/
-    existing_entry = ssh_config_content.match(existing_entry_regex)
+  # ssh_config splits arguments on whitespace, so paths like
+  # C:\Users\John Smith\.ssh\github-work have to be quoted.
+  def self.ssh_config_argument(value)
+    value.match?(/\s/) ? "\"#{value}\"" : value
+  end
 
-    # New input
-    updated_content = if existing_entry
-                        ssh_config_content.gsub(existing_entry.to_s, config_entry)
-                      else
-                        ssh_config_content + "\n#{config_entry}"
-                      end
+  # Backslashes are kept as they are, unlike in shell words.
+  def self.split_ssh_config_arguments(value)
+    value.scan(/"([^"]*)"|(\S+)/).map { |quoted, bare| quoted || bare }
+  end
+
+  def self.remove_ssh_config_entry(account_name)
+    return unless File.exist?(self.ssh_config_path)
+
+    original_content = File.read(self.ssh_config_path)
+    blocks = self.parse_ssh_config(original_content)
+    ssh_config_content = self.remove_config_blocks(blocks) { |block| block[:account] == account_name }
+    self.write_ssh_config(ssh_config_content) unless ssh_config_content == original_content
+  end
+
+  # The entry goes after a blank line, or directly on the next line when the
+  # content doesn't end with a newline. remove_config_blocks undoes either.
+  def self.append_config_entry(content, config_entry)
+    content.empty? ? config_entry : "#{content}\n#{config_entry}"
+  end
+
+  # Joins the blocks back together without the entries of the ones the block
+  # selects, so removing entries added by append_config_entry gives back the
+  # original content. Other comments and blank lines after an entry are kept.
+  def self.remove_config_blocks(blocks)
+    lines = []
+    blocks.each do |block|
+      next lines.concat(block[:lines]) unless yield(block)
+
+      # The entry takes one separator with it: the blank line before it, the
+      # one after it, or the newline append_config_entry added at the end.
+      trailing_lines = self.split_config_block(block)[1]
+      if lines.last&.strip&.empty?
+        lines.pop
+      elsif trailing_lines.first&.strip&.empty?
+        trailing_lines = trailing_lines.drop(1)
+      elsif lines.last && trailing_lines.empty? && block.equal?(blocks.last)
+        lines[-1] = lines.last.chomp
+      end
+      lines.concat(trailing_lines)
+    end
+    lines.join
+  end
+
+  # Splits a block's lines into the entry and the blank lines and comments
+  # after it, which belong to whatever follows rather than to the entry. A
+  # disabled entry ends at its last commented out line.
+  def self.split_config_block(block)
+    trailing_lines = block[:lines].reverse.take_while do |line|
+      block[:disabled] ? !line.start_with?(DISABLED_PREFIX) : self.parse_ssh_config_line(line).nil?
+    end.reverse
+    [block[:lines][0, block[:lines].length - trailing_lines.length], trailing_lines]
+  end
+
+  # Uses a Tempfile for an atomic update and shows the change in verbose mode.
+  # The previous content is kept as a backup for 'multigit restore'.
+  def self.write_ssh_config(updated_content, path = self.ssh_config_path)
+    FileUtils.mkdir_p(File.dirname(path), mode: 0o700)
+    original_content = File.exist?(path) ? File.read(path) : ''
+    File.write("#{path}.multigit.bak", original_content, perm: 0o600) if File.exist?(path)
 
-    # Use Tempfile for Atomic update
     Tempfile.create('ssh_config') do |tempfile|
-      begin
-        tempfile.write(updated_content)
-        tempfile.close
-        FileUtils.mv(tempfile.path, SSH_CONFIG_PATH)
-      rescue => e
-        warn "SSH konfigürasyon dosyası güncellenirken hata oluştu: #{e.message}"
-        return false
+      tempfile.write(updated_content)
+      tempfile.close
+      FileUtils.mv(tempfile.path, path)
+    end
+
+    puts Diff.colorize(Diff.unified_diff(original_content, updated_content)) if self.verbose
+  end
+
+  # Whether an Include line of the main config covers the include file. ssh
+  # resolves ~ against the home directory and relative paths against ~/.ssh,
+  # and the patterns may be globs like config.d/*.
+  def self.include_file_line?(line, exact: false)
+    keyword, value = self.parse_ssh_config_line(line)
+    return false unless keyword&.casecmp?('include')
+
+    patterns = self.split_ssh_config_arguments(value).map do |pattern|
+      pattern.start_with?('~') ? File.expand_path(pattern) : File.expand_path(pattern, self.ssh_dir)
+    end
+    # exact only matches lines that name nothing but the include file itself.
+    return !patterns.empty? && patterns.all?(self.include_file_path) if exact
+
+    patterns.any? { |pattern| File.fnmatch?(pattern, self.include_file_path, File::FNM_PATHNAME | File::FNM_NOESCAPE) }
+  end
+
+  # Switches the scope by adding or removing the Include line, and moves the
+  # managed entries to the file the scope uses. Returns the moved accounts,
+  # or nil when another Include line would keep the include scope.
+  def self.switch_ssh_scope(scope)
+    main_content = File.exist?(self.user_ssh_config_path) ? File.read(self.user_ssh_config_path) : ''
+
+    if scope == 'include'
+      # The Include goes at the top, since ssh only applies it there for every host.
+      unless self.ssh_scope == 'include'
+        self.write_ssh_config("Include #{self.ssh_config_argument(self.include_file_path)}\n#{main_content}",
+                              self.user_ssh_config_path)
       end
+      return self.move_config_entries(self.user_ssh_config_path, self.include_file_path)
     end
 
+    other_lines = main_content.lines.reject { |line| self.include_file_line?(line, exact: true) }
+    return nil if other_lines.any? { |line| self.include_file_line?(line) }
+
+    # Moved first, so a failure can't leave the entries out of sight.
+    moved_accounts = self.move_config_entries(self.include_file_path, self.user_ssh_config_path)
+    main_content = File.exist?(self.user_ssh_config_path) ? File.read(self.user_ssh_config_path) : ''
+    other_content = main_content.lines.reject { |line| self.include_file_line?(line, exact: true) }.join
+    self.write_ssh_config(other_content, self.user_ssh_config_path) unless other_content == main_content
+    moved_accounts
+  end
+
+  # Moves the managed entries of one SSH config file to the end of another.
+  # Entries the other file already has are only removed. Returns the moved
+  # accounts.
+  def self.move_config_entries(from_path, to_path)
+    return [] unless File.exist?(from_path)
+
+    managed_blocks = self.parse_ssh_config(File.read(from_path)).select { |block| block[:account] }
+    return [] if managed_blocks.empty?
+
+    to_content = File.exist?(to_path) ? File.read(to_path) : ''
+    existing_accounts = self.parse_ssh_config(to_content).filter_map { |block| block[:account] }
+    managed_blocks.reject { |block| existing_accounts.include?(block[:account]) }.each do |block|
+      entry = self.split_config_block(block)[0].join
+      entry += "\n" unless entry.end_with?("\n")
+      to_content = self.append_config_entry(to_content, entry)
+    end
+    # Written first, so a failure can't lose entries.
+    self.write_ssh_config(to_content, to_path)
+
+    blocks = self.parse_ssh_config(File.read(from_path))
+    self.write_ssh_config(self.remove_config_blocks(blocks) { |block| block[:account] }, from_path)
+    managed_blocks.map { |block| block[:account] }.uniq
+  end
+
+  # A disabled account keeps its entry in the SSH config, commented out line by line.
+  def self.disable_ssh_config_entry(account_name)
+    self.rewrite_config_entry(account_name, false) { |line| "#{DISABLED_PREFIX}#{line}" }
+  end
+
+  def self.enable_ssh_config_entry(account_name)
+    self.rewrite_config_entry(account_name, true) { |line| line.delete_prefix(DISABLED_PREFIX) }
+  end
+
+  def self.config_entry_disabled?(account_name)
+    blocks = self.read_ssh_config_blocks.select { |block| block[:account] == account_name }
+    blocks.any? { |block| block[:disabled] } && blocks.none? { |block| !block[:disabled] }
+  end
+
+  def self.restore_ssh_config
+    return false unless File.exist?(self.ssh_config_backup_path)
+
+    backup_content = File.read(self.ssh_config_backup_path)
+    return false unless self.valid_ssh_config?(backup_content)
+
+    FileUtils.cp(self.ssh_config_backup_path, self.ssh_config_path)
     true
   end
 
-  def self.remove_ssh_config_entry(account_name)
-    ssh_key_file = File.join(ENV['HOME'], '.ssh', "github-#{account_name}")
-    File.write(SSH_CONFIG_PATH, File.read(SSH_CONFIG_PATH).gsub(/Host github.com-#{account_name}\nHostName github.com\nUser git\nIdentityFile #{ssh_key_file}\n/, ''))
+  # Every line must be blank, a comment or a "Keyword value" / "Keyword=value" pair.
+  def self.valid_ssh_config?(content)
+    content.lines.all? do |line|
+      line.strip.empty? || line.strip.start_with?('#') || line.match?(/\A\s*[A-Za-z]+(\s+|\s*=\s*)\S/)
+    end
+  end
+
+  # Rewrites the entry lines of the account's enabled or disabled block and
+  # leaves the comments and blank lines after it alone.
+  def self.rewrite_config_entry(account_name, disabled)
+    blocks = self.read_ssh_config_blocks
+    block = blocks.find { |candidate| candidate[:account] == account_name && candidate[:disabled] == disabled }
+    return false unless block
+
+    entry_lines, trailing_lines = self.split_config_block(block)
+    block[:lines] = entry_lines.map { |line| yield line } + trailing_lines
+    self.write_ssh_config(blocks.flat_map { |candidate| candidate[:lines] }.join)
+    true
+  end
+
+  # Accounts that have an enabled managed Host block in the SSH config.
+  def self.config_entry_accounts
+    self.enabled_config_entry_accounts.uniq
+  end
+
+  def self.enabled_config_entry_accounts
+    self.read_ssh_config_blocks.reject { |block| block[:disabled] }.filter_map { |block| block[:account] }
+  end
+
+  def self.read_ssh_config_blocks
+    return [] unless File.exist?(self.ssh_config_path)
+
+    self.parse_ssh_config(File.read(self.ssh_config_path))
+  end
+
+  # Splits the SSH config into blocks that each start at a Host or Match line,
+  # with the lines before the first one as a block without a keyword. Comments
+  # and blank lines stay in the block they appear in, so joining every block's
+  # :lines gives back the original content. A Host line behind DISABLED_PREFIX
+  # starts a disabled block, whose options are read from its prefixed lines.
+  def self.parse_ssh_config(content)
+    blocks = [{ keyword: nil, aliases: [], options: {}, lines: [], disabled: false }]
+
+    content.lines.each do |line|
+      disabled = line.start_with?(DISABLED_PREFIX)
+      keyword, value = self.parse_ssh_config_line(line.delete_prefix(DISABLED_PREFIX))
+      if keyword && %w[host match].include?(keyword.downcase)
+        blocks << { keyword: keyword, aliases: value.split, options: {}, lines: [], disabled: disabled }
+      elsif keyword && disabled == blocks.last[:disabled]
+        # Like ssh, the first value given for an option wins.
+        blocks.last[:options][keyword.downcase] ||= value
+      end
+      blocks.last[:lines] << line
+    end
+
+    blocks.each { |block| block[:account] = self.managed_account(block) }
+  end
+
+  # Accepts both "Keyword value" and "Keyword=value". Comments and blank lines
+  # return nil.
+  def self.parse_ssh_config_line(line)
+    stripped = line.strip
+    return nil if stripped.empty? || stripped.start_with?('#')
+
+    keyword, value = stripped.split(/\s*=\s*|\s+/, 2)
+    [keyword, value.to_s.strip]
+  end
+
+  # The account of a block multigit wrote, or nil for any other block.
+  def self.managed_account(block)
+    return nil unless block[:keyword]&.casecmp?('host') && block[:aliases].length == 1
+
+    account_name = block[:aliases].first[/\Agithub\.com-(.+)\z/, 1]
+    return nil unless account_name && block[:options]['hostname'] == 'github.com'
+
+    account_name
+  end
+
+  # Removes managed entries whose account isn't in known_accounts, leaving other hosts untouched.
+  def self.prune_orphaned_config_entries(known_accounts)
+    orphaned_accounts = self.config_entry_accounts - known_accounts
+    orphaned_accounts.each { |account_name| self.remove_ssh_config_entry(account_name) }
+    orphaned_accounts
+  end
+
+  # Disabled accounts still have their (commented out) entry, so they don't count.
+  def self.accounts_missing_config_entry
+    (self.accounts - self.config_entry_accounts).reject { |account_name| self.config_entry_disabled?(account_name) }
+  end
+
+  def self.duplicate_config_entry_accounts
+    self.enabled_config_entry_accounts.tally.select { |_, count| count > 1 }.keys
+  end
+
+  # Collapses repeated managed entries for an account into the last one and
+  # returns how many entries were removed.
+  def self.dedupe_config_entries
+    return 0 unless File.exist?(self.ssh_config_path)
+
+    blocks = self.read_ssh_config_blocks
+    entry_blocks = blocks.select { |block| block[:account] && !block[:disabled] }
+    last_blocks = entry_blocks.group_by { |block| block[:account] }.transform_values(&:last)
+    duplicate_blocks = entry_blocks.reject { |block| last_blocks[block[:account]].equal?(block) }
+    return 0 if duplicate_blocks.empty?
+
+    self.write_ssh_config(self.remove_config_blocks(blocks) { |block| duplicate_blocks.any? { |duplicate| duplicate.equal?(block) } })
+    duplicate_blocks.length
   end
 
   def self.delete(account_name)
-    ssh_key_file = File.join(ENV['HOME'], '.ssh', "github-#{account_name}")
-    FileUtils.rm_rf([ssh_key_file, "#{ssh_key_file}.pub"])
+    artifacts = self.managed_artifacts(account_name)
+    FileUtils.rm_rf(artifacts[:key_files] + [artifacts[:ssh_options_file]])
+  end
+
+  # Moves the key pair to id_github_<account>, out of the github-* names that
+  # make up accounts, so the keys stay usable but multigit stops managing them.
+  # Returns the new private key path, or nil when that name is already taken.
+  def self.release_keys(account_name)
+    key_path = self.ssh_key_path(account_name)
+    released_path = self.released_key_path(account_name)
+    return nil if File.exist?(released_path) || File.exist?("#{released_path}.pub")
+
+    FileUtils.mv(key_path, released_path)
+    FileUtils.mv("#{key_path}.pub", "#{released_path}.pub") if File.exist?("#{key_path}.pub")
+    FileUtils.rm_f(self.managed_artifacts(account_name)[:ssh_options_file])
+    released_path
+  end
+
+  def self.released_key_path(account_name)
+    File.join(self.ssh_dir, "id_github_#{account_name}")
+  end
+
+  # What multigit creates for an account: its key pair, the file with its
+  # extra SSH options and the Host alias of its SSH config entry. Git config
+  # is set per repository by 'use' and isn't included.
+  def self.managed_artifacts(account_name)
+    key_path = self.ssh_key_path(account_name)
+    {
+      key_files: [key_path, "#{key_path}.pub"],
+      ssh_options_file: "#{key_path}.ssh_options",
+      host_alias: "github.com-#{account_name}"
+    }
+  end
+
+  # The --ssh-opt options of an account, kept next to its key in SSH config
+  # syntax so the entry can be written again after it was removed.
+  def self.ssh_options(account_name)
+    path = self.managed_artifacts(account_name)[:ssh_options_file]
+    return {} unless File.exist?(path)
+
+    File.readlines(path).filter_map { |line| self.parse_ssh_config_line(line) }.to_h
+  end
+
+  def self.save_ssh_options(account_name, ssh_options)
+    path = self.managed_artifacts(account_name)[:ssh_options_file]
+    if ssh_options.empty?
+      FileUtils.rm_f(path)
+    else
+      File.write(path, ssh_options.map { |key, value| "#{key} #{value}\n" }.join, perm: 0o600)
+    end
   end
 
   def self.check_key_exists(account_name)
@@ -83,23 +457,173 @@ class KeyManager
     true
   end
 
-  def self.add_key_to_agent(account_name)
-    command = "ssh-add --apple-use-keychain #{self.ssh_key_path(account_name)}"
+  # Adding the key to the agent is a convenience, so failures only warn.
+  # The key and its SSH config entry remain usable without a running agent.
+  # A freshly started agent may not accept connections yet, so failed
+  # attempts are retried with a growing delay.
+  def self.add_key_to_agent(account_name, attempts = 3, backoff = 0.5)
+    unless self.key_exists?(account_name)
+      warn "Error adding SSH key to agent: #{self.ssh_key_path(account_name)} not found"
+      return false
+    end
+
+    command = ['ssh-add']
+    command << '--apple-use-keychain' if RUBY_PLATFORM.include?("darwin")
+    command << self.ssh_key_path(account_name)
+
+    error_message = nil
+    attempts.times do |attempt|
+      sleep(backoff * attempt) if attempt.positive?
+
+      begin
+        _stdout, error_message, status = Open3.capture3(*command)
+      rescue SystemCallError => e
+        warn "Error adding SSH key to agent: #{e.message}"
+        return false
+      end
 
-    Open3.popen3(command) do |stdin, stdout, stderr, wait_thr|
-      error_message = stderr.read
-      if error_message.empty?
+      if status.success?
         puts "SSH key added to agent successfully."
         return true
-      else
-        warn "Error adding SSH key to agent: #{error_message}"
-        return false
       end
     end
+
+    warn "Error adding SSH key to agent: #{error_message}"
+    false
+  end
+
+  # GitHub ends the session with exit status 1 even when authentication works,
+  # so its greeting is what tells a working key from a rejected one.
+  def self.test_connection(account_name)
+    _stdout, stderr, _status = Open3.capture3('ssh', '-T', '-o', 'BatchMode=yes', '-F', self.user_ssh_config_path,
+                                              "git@#{self.managed_artifacts(account_name)[:host_alias]}")
+    [stderr.include?('successfully authenticated'), stderr.strip]
+  rescue SystemCallError => e
+    [false, e.message]
   end
 
   def self.ssh_key_path(account_name)
-    File.join(ENV['HOME'], '.ssh', "github-#{account_name}")
+    File.join(self.ssh_dir, "github-#{account_name}")
+  end
+
+  def self.key_exists?(account_name)
+    File.exist?(self.ssh_key_path(account_name))
+  end
+
+  # ssh refuses private keys that group or others can access. Windows has no
+  # such permission bits, so keys there are always considered secure.
+  def self.secure_key_permissions?(account_name)
+    return true if Gem.win_platform?
+
+    (File.stat(self.ssh_key_path(account_name)).mode & 0o077).zero?
+  end
+
+  def self.fix_key_permissions(account_name)
+    File.chmod(0o600, self.ssh_key_path(account_name))
+  end
+
+  # An account is a private key named github-<account>, which is what its SSH
+  # config entry uses. The public key is optional, e.g. for imported keys.
+  def self.accounts
+    Dir.glob(File.join(self.ssh_dir, 'github-*')).filter_map do |path|
+      account_name = File.basename(path).delete_prefix('github-')
+      account_name if Validation.valid_account_name?(account_name) && File.file?(path)
+    end.sort
+  end
+
+  def self.suggest_account(account_name)
+    DidYouMean::SpellChecker.new(dictionary: self.accounts).correct(account_name).first
+  end
+
+  # ssh-keygen stores the -C comment at the end of the public key. multigit writes
+  # a bare email there, other tools often use "name <email>" or freeform text.
+  def self.parse_key_comment(public_key_file)
+    comment = File.read(public_key_file).split(' ', 3)[2].to_s.strip
+
+    if (match = comment.match(/\A(.*?)\s*<([^<>]+)>\z/))
+      name, email = match[1], match[2]
+    elsif Validation.valid_email?(comment)
+      name, email = nil, comment
+    else
+      name, email = comment, nil
+    end
+
+    name = nil if name.to_s.empty?
+    email = nil unless Validation.valid_email?(email)
+    [name, email]
+  end
+
+  def self.public_key_email(account_name)
+    public_key_file = "#{self.ssh_key_path(account_name)}.pub"
+    return nil unless File.exist?(public_key_file)
+
+    self.parse_key_comment(public_key_file)[1]
+  end
+
+  # The key type and base64 data without the comment, as GitHub returns keys.
+  # Returns nil when the account has no public key.
+  def self.public_key_material(account_name)
+    public_key_file = "#{self.ssh_key_path(account_name)}.pub"
+    return nil unless File.exist?(public_key_file)
+
+    File.read(public_key_file).split[0, 2].join(' ')
+  end
+
+  # git checks SSH commit signatures against this file (gpg.ssh.allowedSignersFile).
+  def self.allowed_signers_path
+    File.join(self.ssh_dir, 'allowed_signers')
+  end
+
+  def self.allowed_signer_line(email, account_name)
+    "#{email} #{self.public_key_material(account_name)}"
+  end
+
+  # One "<email> <key>" line per account. Accounts without a public key or an
+  # email in its comment are returned separately as skipped.
+  def self.allowed_signers(account_names)
+    skipped, signers = account_names.partition do |account_name|
+      !File.exist?("#{self.ssh_key_path(account_name)}.pub") || self.public_key_email(account_name).nil?
+    end
+    [signers.map { |account_name| self.allowed_signer_line(self.public_key_email(account_name), account_name) }, skipped]
+  end
+
+  # Returns false when the file already has the entry.
+  def self.add_allowed_signer(email, account_name)
+    line = self.allowed_signer_line(email, account_name)
+    content = File.exist?(self.allowed_signers_path) ? File.read(self.allowed_signers_path) : ''
+    return false if content.lines.map(&:chomp).include?(line)
+
+    content += "\n" unless content.empty? || content.end_with?("\n")
+    File.write(self.allowed_signers_path, "#{content}#{line}\n")
+    true
+  end
+
+  def self.account_details(account_name)
+    {
+      name: account_name,
+      email: self.public_key_email(account_name),
+      key_path: self.ssh_key_path(account_name)
+    }
+  end
+
+  def self.accounts_with_email(email)
+    self.accounts.select { |account_name| self.public_key_email(account_name)&.casecmp?(email) }
+  end
+
+  def self.public_key_fingerprint(account_name)
+    stdout, status = Open3.capture2('ssh-keygen', '-lf', "#{self.ssh_key_path(account_name)}.pub")
+    status.success? ? stdout.split[1] : nil
+  rescue SystemCallError
+    nil
+  end
+
+  # Names and emails match case-insensitive substrings, fingerprints must match exactly.
+  def self.find_accounts(query)
+    self.accounts.select do |account_name|
+      account_name.downcase.include?(query.downcase) ||
+        self.public_key_email(account_name).to_s.downcase.include?(query.downcase) ||
+        (query.start_with?('SHA256:') && self.public_key_fingerprint(account_name) == query)
+    end
   end
 
   def self.copy_public_key_to_clipboard(account_name)
@@ -112,12 +636,11 @@ class KeyManager
       return false
     end
 
-    begin
-      IO.popen('pbcopy', 'w') { |f| f << public_key_content }
+    if SystemTools.copy_to_clipboard(public_key_content)
       puts "SSH public key copied to clipboard."
-    rescue => e
-      warn "SSH anahtarını panoya kopyalarken hata oluştu: #{e.message}"
-      return false
+    else
+      warn "No clipboard tool found. Copy the SSH public key below manually:"
+      puts public_key_content
     end
 
     true
@@ -0,0 +1,41 @@
+# frozen_string_literal: true
+
+module SystemTools
+  # Tried in order on Linux: Wayland first, then the common X11 tools.
+  LINUX_CLIPBOARD_COMMANDS = [
+    ['wl-copy'],
+    ['xclip', '-selection', 'clipboard'],
+    ['xsel', '--clipboard', '--input']
+  ].freeze
+
+  # Returns false when no clipboard tool is installed or all of them fail.
+  def self.copy_to_clipboard(text)
+    commands = if RUBY_PLATFORM.include?('darwin')
+                 [['pbcopy']]
+               elsif Gem.win_platform?
+                 [['clip.exe']]
+               else
+                 LINUX_CLIPBOARD_COMMANDS
+               end
+
+    commands.any? do |command|
+      IO.popen(command, 'w', err: File::NULL) { |io| io << text }
+      $?.success?
+    rescue SystemCallError
+      false
+    end
+  end
+
+  # Opens the URL with the platform's default handler. Returns false when no
+  # opener is available, e.g. on a headless machine.
+  def self.open_url(url)
+    command = if RUBY_PLATFORM.include?('darwin')
+                ['open', url]
+              elsif Gem.win_platform?
+                ['cmd', '/c', 'start', '', url]
+              else
+                ['xdg-open', url]
+              end
+    system(*command, out: File::NULL, err: File::NULL) ? true : false
+  end
+end
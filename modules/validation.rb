@@ -1,11 +1,15 @@
 # frozen_string_literal: true
 
 module Validation
+  # Account names become SSH host aliases and key file names, so only
+  # GitHub-style names are accepted: no whitespace, slashes or '@'.
   def self.valid_account_name?(account_name)
-    account_name.match?(/^[a-z\d](?:[a-z\d]|-(?=[a-z\d])){0,38}$/i)
+    account_name.to_s.match?(/\A[a-z\d](?:[a-z\d]|-(?=[a-z\d])){0,38}\z/i)
   end
 
+  # Plus-addressing and subdomains are accepted. The domain must contain a
+  # dot, so local-only addresses like 'foo@bar' are rejected.
   def self.valid_email?(email)
-    email.match?(/\A[\w+\-.]+@[a-z\d\-]+(\.[a-z\d\-]+)*\.[a-z]+\z/i)
+    email.to_s.match?(/\A[\w+\-.]+@[a-z\d\-]+(\.[a-z\d\-]+)*\.[a-z]+\z/i)
   end
 end
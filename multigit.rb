@@ -6,14 +6,20 @@
 require 'fileutils'
 require 'open3'
 require 'optparse'
+require 'json'
+require 'shellwords'
 require 'colorize'
 require_relative 'modules/localization'
 require_relative 'modules/key_manager'
 require_relative 'modules/validation'
 require_relative 'modules/input_manager'
+require_relative 'modules/git_actions'
+require_relative 'modules/system_tools'
+require_relative 'modules/github_api'
 
 class MultiGit
   VERSION = '1.0.0'
+  GITHUB_SSH_SETTINGS_URL = 'https://github.com/settings/ssh/new'
   class << self
     attr_accessor :debug_mode
   end
@@ -40,12 +46,61 @@ class MultiGit
         puts "multigit v#{VERSION}"
         exit
       end
+      opts.on("--no-color", "Disable colored output") do
+        String.disable_colorization = true
+      end
+      opts.on("--verbose", "Show changes made to the SSH config") do
+        KeyManager.verbose = true
+      end
+      opts.on("--ssh-dir DIR", "Use DIR instead of ~/.ssh for keys and the SSH config") do |dir|
+        options[:ssh_dir] = File.expand_path(dir)
+      end
       opts.separator "Commands:"
       opts.separator "  create\t<account_name> <account_email>\tCreate a new SSH key for a GitHub account"
+      opts.separator "\t\t-p\t\t\t\tProtect the key with a passphrase"
+      opts.separator "\t\t--force\t\t\t\tReplace an existing account's key and config entry"
+      opts.separator "\t\t--open\t\t\t\tOpen GitHub's SSH key settings page afterwards"
+      opts.separator "\t\t--email-from-git\t\tUse the email from git config instead of <account_email>"
+      opts.separator "\t\t--ssh-config-only\t\tOnly add the config entry for an existing key"
+      opts.separator "\t\t--allow-duplicate-email\t\tDon't warn when another account uses the same email"
+      opts.separator "\t\t--ssh-opt <key>=<value>\t\tAdd an SSH option (e.g. Port=2222) to the config entry"
       opts.separator "  delete\t<account_name>\t\t\tDelete an SSH key for a GitHub account"
+      opts.separator "\t\t--all\t\t\t\tDelete every account"
+      opts.separator "\t\t--keep-keys\t\t\tKeep the key files, renamed to id_github_<account_name>"
+      opts.separator "\t\t--force\t\t\t\tDon't ask for confirmation with --all"
       opts.separator "  copy\t\t<account_name>\t\t\tCopy the public key for a GitHub account to the clipboard"
-      opts.separator "  use\t\tUse an SSH key for a GitHub account in the current directory"
-      opts.separator "  list\t\tList all SSH keys for GitHub accounts"
+      opts.separator "\t\t--open\t\t\t\tOpen GitHub's SSH key settings page afterwards"
+      opts.separator "  upload\t<account_name>\t\t\tAdd the public key to GitHub using $GITHUB_TOKEN"
+      opts.separator "\t\t--token <token>\t\t\tUse this token instead of $GITHUB_TOKEN"
+      opts.separator "  github-keys\t\t\t\t\tCompare local keys with the keys on GitHub"
+      opts.separator "\t\t--token <token>\t\t\tUse this token instead of $GITHUB_TOKEN"
+      opts.separator "  use\t\t[account_name]\t\t\tUse an SSH key for a GitHub account in the current directory"
+      opts.separator "\t\t--force\t\t\t\tOverwrite a git email that no account uses without asking"
+      opts.separator "\t\t--print\t\t\t\tPrint the git commands instead of running them"
+      opts.separator "\t\t--sign\t\t\t\tSign commits with the account's SSH key"
+      opts.separator "  remote\t<account_name> [remote]\t\tPoint a GitHub remote (default origin) at the account"
+      opts.separator "  detect\t\t\t\t\tShow which account the current repository uses"
+      opts.separator "  autoswitch\t\t\t\t\tApply the account named in the repository's .multigit file"
+      opts.separator "  test\t\t[account_name] [--all]\t\tCheck that accounts can authenticate with GitHub"
+      opts.separator "  allowed-signers\t[--out <file>]\t\tPrint or write an allowed signers file for every account"
+      opts.separator "  keypath\t<account_name>\t\t\tPrint the path of an account's private key"
+      opts.separator "  passphrase\t<account_name>\t\t\tChange or remove the passphrase of an account's key"
+      opts.separator "  list\t\t[--format <format>]\t\tList all SSH keys for GitHub accounts"
+      opts.separator "\t\t\t\t\t\tFormat is 'json' or uses %{name}, %{email} and %{key_path}"
+      opts.separator "  disable\t<account_name>\t\t\tComment out an account's SSH config entry without deleting it"
+      opts.separator "  enable\t<account_name>\t\t\tRestore a disabled account's SSH config entry"
+      opts.separator "  preview\t<account_name>\t\t\tPrint the SSH config entry create would add"
+      opts.separator "  find\t\t<query>\t\t\t\tFind accounts by name, email or key fingerprint"
+      opts.separator "  purge\t\t[--force]\t\t\tDelete every account's keys and SSH config entry"
+      opts.separator "  restore\t\t\t\t\tRestore the SSH config from the backup taken before the last change"
+      opts.separator "  doctor\t[--fix]\t\t\t\tCheck for SSH config problems and optionally fix them"
+      opts.separator "  config\t[--json]\t\t\tShow the SSH paths and number of accounts multigit uses"
+      opts.separator "  ssh-scope\t[user|include]\t\t\tShow or change where multigit keeps its SSH config entries"
+      opts.separator "\t\t\t\t\t\t'include' uses ~/.ssh/config.d/multigit, Included by ~/.ssh/config"
+      opts.separator ""
+      opts.separator "Environment:"
+      opts.separator "  MULTIGIT_POST_USE_HOOK\t\t\tCommand to run after use switches accounts,"
+      opts.separator "\t\t\t\t\t\twith $MULTIGIT_ACCOUNT and $MULTIGIT_EMAIL set"
     end
 
     opt_parser.order!(args)
@@ -66,11 +121,49 @@ class MultiGit
       when 'create'
         create_account(*@args)
       when 'delete'
-        delete_account(get_account_name)
+        delete_account(*@args)
       when 'copy'
-        copy_public_key(get_account_name)
+        copy_public_key(*@args)
+      when 'upload'
+        upload_public_key(*@args)
+      when 'github-keys'
+        reconcile_github_keys(*@args)
       when 'use'
-        use_account(get_account_name)
+        use_account(*@args)
+      when 'remote'
+        switch_remote(*@args)
+      when 'detect'
+        detect_account
+      when 'autoswitch'
+        autoswitch
+      when 'test'
+        test_connections(*@args)
+      when 'allowed-signers'
+        print_allowed_signers(*@args)
+      when 'keypath'
+        print_key_path(@args.first || get_account_name)
+      when 'passphrase'
+        change_passphrase(@args.first || get_account_name)
+      when 'list'
+        list_accounts(*@args)
+      when 'disable'
+        toggle_account(@args.first || get_account_name, false)
+      when 'enable'
+        toggle_account(@args.first || get_account_name, true)
+      when 'preview'
+        preview_ssh_config_entry(@args.first || get_account_name)
+      when 'find'
+        find_accounts(@args.first)
+      when 'purge'
+        purge(*@args)
+      when 'restore'
+        restore_ssh_config
+      when 'doctor'
+        doctor(*@args)
+      when 'config'
+        show_config(*@args)
+      when 'ssh-scope'
+        set_ssh_scope(@args.first)
       else
         raise ArgumentError, Localization.get_message("system.incorrect_command").colorize(:color => :red)
       end
@@ -92,13 +185,48 @@ class MultiGit
   def create_account(*args)
     passphrase_option = args.include?('-p')
     args.delete('-p')
-    if args.length == 2
+    force = args.include?('--force')
+    args.delete('--force')
+    open_settings = args.include?('--open')
+    args.delete('--open')
+    email_from_git = args.include?('--email-from-git')
+    args.delete('--email-from-git')
+    ssh_config_only = args.include?('--ssh-config-only')
+    args.delete('--ssh-config-only')
+    allow_duplicate_email = args.include?('--allow-duplicate-email')
+    args.delete('--allow-duplicate-email')
+    ssh_options = {}
+    while (index = args.index('--ssh-opt'))
+      key, value = args.delete_at(index + 1).to_s.split('=', 2)
+      args.delete_at(index)
+      # Host and Match would start a new block inside the entry.
+      unless key.to_s.match?(/\A[A-Za-z]+\z/) && !%w[host match].include?(key.downcase) &&
+             !value.to_s.strip.empty? && !value.include?("\n")
+        puts Localization.get_message("error.invalid_ssh_option")
+        exit 1
+      end
+      ssh_options[key] = value
+    end
+    if email_from_git
+      account_email = GitActions.get_config('user.email').to_s
+      account_name = args.first
+      if account_name.nil?
+        puts Localization.get_message("input.account_name")
+        account_name = STDIN.gets.chomp
+      end
+    elsif args.length == 2
       account_name, account_email = args
     else
-      puts Localization.get_message("input.account_name")
-      account_name = STDIN.gets.chomp
-      puts Localization.get_message("input.email")
-      account_email = STDIN.gets.chomp
+      unless STDIN.tty?
+        puts Localization.get_message("error.missing_account_details")
+        exit 1
+      end
+
+      account_name, account_email = get_account_details
+      unless passphrase_option
+        puts Localization.get_message("ssh.add_passphrase")
+        passphrase_option = STDIN.gets.chomp.downcase == 'y'
+      end
     end
     unless Validation.valid_account_name?(account_name)
       puts Localization.get_message("error.invalid_account_name")
@@ -110,25 +238,79 @@ class MultiGit
       exit 1
     end
 
+    # Re-creating an account keeps its SSH options unless new ones are given.
+    ssh_options = KeyManager.ssh_options(account_name) if ssh_options.empty?
+
+    if ssh_config_only
+      add_ssh_config_entry_for_existing_key(account_name, ssh_options)
+      return
+    end
+
+    if force && KeyManager.key_exists?(account_name)
+      puts Localization.get_message("input.confirm_overwrite")
+      unless STDIN.gets.chomp == 'y'
+        puts Localization.get_message("system.operation_cancelled")
+        return
+      end
+      KeyManager.remove_ssh_config_entry(account_name)
+      KeyManager.delete(account_name)
+    end
+
     KeyManager.check_key_exists(account_name)
 
-    if passphrase_option
-      KeyManager.create_ssh_key(account_name, account_email, true)
-    else
-      KeyManager.create_ssh_key(account_name, account_email, false)
+    unless allow_duplicate_email
+      accounts_with_email = KeyManager.accounts_with_email(account_email)
+      unless accounts_with_email.empty?
+        puts format(Localization.get_message("ssh.duplicate_email"), accounts: accounts_with_email.join(', ')).colorize(:color => :yellow)
+      end
     end
 
+    exit 1 unless KeyManager.create_ssh_key(account_name, account_email, passphrase_option)
+
     KeyManager.add_key_to_agent(account_name)
-    KeyManager.add_ssh_config_entry(account_name)
+
+    unless KeyManager.add_ssh_config_entry(account_name, ssh_options)
+      # Remove the just-created key files so a retry doesn't fail with "key exists".
+      begin
+        KeyManager.delete(account_name)
+      rescue => e
+        warn "Error removing SSH key after failed create: #{e.message}"
+      end
+      puts Localization.get_message("error.create_rolled_back")
+      exit 1
+    end
 
     puts Localization.get_message("ssh.created")
     puts Localization.get_message("ssh.copy_public_key")
 
     KeyManager.copy_public_key_to_clipboard(account_name)
+    open_github_ssh_settings if open_settings
+  end
+
+  # Registers a key created outside multigit. The key is never touched, even
+  # if adding the config entry fails.
+  def add_ssh_config_entry_for_existing_key(account_name, ssh_options)
+    unless KeyManager.key_exists?(account_name)
+      puts format(Localization.get_message("error.existing_key_not_found"), path: KeyManager.ssh_key_path(account_name))
+      exit 1
+    end
+
+    exit 1 unless KeyManager.add_ssh_config_entry(account_name, ssh_options)
+
+    puts Localization.get_message("ssh.config_entry_added")
   end
 
   def delete_account(*args)
-    account_name = args
+    force = args.include?('--force')
+    args.delete('--force')
+    keep_keys = args.include?('--keep-keys')
+    args.delete('--keep-keys')
+    if args.include?('--all')
+      delete_all_accounts(force, keep_keys)
+      return
+    end
+
+    account_name = args.first
 
     if account_name.nil? || account_name.empty?
       puts Localization.get_message("input.account_name")
@@ -140,22 +322,72 @@ class MultiGit
       exit 1
     end
 
-    KeyManager.check_key_exists(account_name)
+    exit_account_not_found(account_name) unless KeyManager.key_exists?(account_name)
 
     puts Localization.get_message("input.confirm_delete")
     confirm_delete = STDIN.gets.chomp
 
     if confirm_delete == 'y'
-      KeyManager.remove_ssh_config_entry(account_name)
-      KeyManager.delete(account_name)
-      puts Localization.get_message("ssh.deleted")
+      if keep_keys
+        released_path = release_keys(account_name)
+        KeyManager.remove_ssh_config_entry(account_name)
+        puts format(Localization.get_message("ssh.config_entry_deleted"), path: released_path)
+      else
+        KeyManager.remove_ssh_config_entry(account_name)
+        KeyManager.delete(account_name)
+        puts Localization.get_message("ssh.deleted")
+      end
     else
       puts Localization.get_message("system.operation_cancelled")
     end
   end
 
+  def delete_all_accounts(force, keep_keys = false)
+    account_names = KeyManager.accounts
+
+    unless force
+      puts format(Localization.get_message("input.confirm_delete_all"), count: account_names.length)
+      unless STDIN.gets.chomp == 'y'
+        puts Localization.get_message("system.operation_cancelled")
+        return
+      end
+    end
+
+    deleted, failed = delete_accounts(account_names, keep_keys)
+    puts format(Localization.get_message("ssh.delete_summary"), deleted: deleted.length, failed: failed.length)
+    exit 1 unless failed.empty?
+  end
+
+  # Keeps going past failures so one broken account doesn't block the rest.
+  def delete_accounts(account_names, keep_keys = false)
+    failed = []
+    deleted = account_names.select do |account_name|
+      release_keys(account_name) if keep_keys
+      KeyManager.remove_ssh_config_entry(account_name)
+      KeyManager.delete(account_name) unless keep_keys
+      puts format(Localization.get_message("ssh.account_deleted"), account: account_name)
+      true
+    rescue StandardError => e
+      puts format(Localization.get_message("error.delete_failed"), account: account_name, message: e.message)
+      failed << account_name
+      false
+    end
+    [deleted, failed]
+  end
+
+  # Raises when the keys can't be moved, before anything else is changed.
+  def release_keys(account_name)
+    released_path = KeyManager.release_keys(account_name)
+    return released_path if released_path
+
+    raise format(Localization.get_message("error.released_keys_exist"),
+                 account: account_name, path: KeyManager.released_key_path(account_name))
+  end
+
   def copy_public_key(*args)
-    account_name = args
+    open_settings = args.include?('--open')
+    args.delete('--open')
+    account_name = args.first
 
     if account_name.nil? || account_name.empty?
       puts Localization.get_message("input.account_name")
@@ -168,22 +400,121 @@ class MultiGit
     end
 
     KeyManager.copy_public_key_to_clipboard(account_name)
+    open_github_ssh_settings if open_settings
+  end
+
+  def upload_public_key(*args)
+    token = github_token(args)
+    account_name = args.first || get_account_name
+
+    unless Validation.valid_account_name?(account_name)
+      puts Localization.get_message("error.invalid_account_name")
+      exit 1
+    end
+
+    exit_account_not_found(account_name) unless KeyManager.key_exists?(account_name)
+
+    public_key_path = "#{KeyManager.ssh_key_path(account_name)}.pub"
+    unless File.exist?(public_key_path)
+      puts format(Localization.get_message("error.public_key_not_found"), account: account_name)
+      exit 1
+    end
+
+    public_key = File.read(public_key_path).strip
+    case GitHubAPI.upload_public_key("multigit #{account_name}", public_key, token)
+    when :created
+      puts Localization.get_message("github.key_uploaded")
+    when :duplicate
+      puts Localization.get_message("github.key_already_uploaded")
+    when :unauthorized
+      puts Localization.get_message("error.github_unauthorized")
+      exit 1
+    else
+      puts Localization.get_message("error.github_request_failed")
+      exit 1
+    end
   end
 
-  def use_account(name)
-    KeyManager.check_key_exists(name)
+  def reconcile_github_keys(*args)
+    status, github_keys = GitHubAPI.list_public_keys(github_token(args))
+    case status
+    when :unauthorized
+      puts Localization.get_message("error.github_unauthorized")
+      exit 1
+    when :failed
+      puts Localization.get_message("error.github_request_failed")
+      exit 1
+    end
+
+    github_materials = github_keys.map { |github_key| github_key['key'].split[0, 2].join(' ') }
+    local_materials = KeyManager.accounts.map { |account_name| KeyManager.public_key_material(account_name) }
+
+    KeyManager.accounts.zip(local_materials).each do |account_name, material|
+      if material.nil?
+        puts format(Localization.get_message("error.public_key_not_found"), account: account_name)
+        next
+      end
+
+      message_key = github_materials.include?(material) ? "github.key_on_github" : "github.key_not_on_github"
+      puts format(Localization.get_message(message_key), account: account_name)
+    end
+
+    github_keys.zip(github_materials).each do |github_key, material|
+      next if local_materials.include?(material)
+
+      puts format(Localization.get_message("github.key_not_local"), title: github_key['title'])
+    end
+  end
+
+  # Removes --token <token> from args, falling back to $GITHUB_TOKEN.
+  def github_token(args)
+    token_index = args.index('--token')
+    token = token_index ? args.slice!(token_index, 2)[1] : ENV['GITHUB_TOKEN']
+
+    if token.to_s.empty?
+      puts Localization.get_message("error.missing_github_token")
+      exit 1
+    end
+    token
+  end
+
+  def open_github_ssh_settings
+    return if SystemTools.open_url(GITHUB_SSH_SETTINGS_URL)
 
-    ssh_config = File.read(ssh_config_path)
-    unless ssh_config.include?("Host github.com-#{name}")
+    puts format(Localization.get_message("error.open_url_failed"), url: GITHUB_SSH_SETTINGS_URL)
+  end
+
+  def use_account(*args)
+    force = args.include?('--force')
+    args.delete('--force')
+    print_only = args.include?('--print')
+    args.delete('--print')
+    sign = args.include?('--sign')
+    args.delete('--sign')
+    name = args.first || select_account
+
+    unless Validation.valid_account_name?(name)
+      puts Localization.get_message("error.invalid_account_name")
+      exit 1
+    end
+
+    exit_account_not_found(name) unless KeyManager.key_exists?(name)
+
+    if KeyManager.config_entry_disabled?(name)
+      puts format(Localization.get_message("error.account_disabled"), account: name)
+      exit 1
+    end
+
+    unless KeyManager.config_entry_accounts.include?(name)
       puts "No matching SSH configuration for '#{name}'."
       exit 1
     end
 
-    unless Dir.exist?(File.join(Dir.pwd, '.git'))
+    unless print_only || GitActions.repo?
       puts "No git repository found in the current directory. Do you want to initialize a new repository? (Y/n)"
       initialize_repo = STDIN.gets.chomp.downcase
       if initialize_repo == 'y' || initialize_repo == ''
-        system("git init")
+        GitActions.init_repo
       else
         puts "Operation cancelled. No git repository initialized."
         return
@@ -197,33 +528,440 @@ class MultiGit
     puts "Enter new remote URL:"
     new_url = STDIN.gets.chomp
 
-    system("git config user.name '#{new_name}'")
-    system("git config user.email '#{new_email}'")
-    system("git remote set-url origin '#{new_url}'")
+    public_key_path = "#{KeyManager.ssh_key_path(name)}.pub"
+    if sign && !File.exist?(public_key_path)
+      puts format(Localization.get_message("error.public_key_not_found"), account: name)
+      exit 1
+    end
+
+    commands = GitActions.use_commands(new_name, new_email, new_url, signing_key: (public_key_path if sign),
+                                       allowed_signers_file: KeyManager.allowed_signers_path)
+    if print_only
+      commands.each { |command| puts Shellwords.join(command) }
+      return
+    end
+
+    # use only writes the repository's config, so a global email isn't overwritten.
+    current_email = GitActions.get_config('user.email', local: true)
+    known_emails = KeyManager.accounts.filter_map { |account_name| KeyManager.public_key_email(account_name)&.downcase }
+    if !force && current_email && !current_email.casecmp?(new_email) && !known_emails.include?(current_email.downcase)
+      puts format(Localization.get_message("input.confirm_overwrite_git_email"), email: current_email)
+      unless STDIN.gets.chomp == 'y'
+        puts Localization.get_message("system.operation_cancelled")
+        return
+      end
+    end
+
+    unless GitActions.run_commands(commands)
+      puts Localization.get_message("error.git_config_failed")
+      exit 1
+    end
+    KeyManager.add_allowed_signer(new_email, name) if sign
 
     puts "Git configuration updated with new name, email, and remote URL."
+
+    run_post_use_hook(name, new_email)
+  end
+
+  # The hook is a shell command, e.g. to refresh a prompt cache. Its failure
+  # doesn't undo the switch.
+  def run_post_use_hook(account_name, email)
+    hook = ENV['MULTIGIT_POST_USE_HOOK'].to_s
+    return if hook.empty?
+    return if system({ 'MULTIGIT_ACCOUNT' => account_name, 'MULTIGIT_EMAIL' => email }, hook)
+
+    puts format(Localization.get_message("error.hook_failed"), hook: hook).colorize(:color => :yellow)
+  end
+
+  def switch_remote(*args)
+    account_name = args[0] || get_account_name
+    remote = args[1] || 'origin'
+
+    unless Validation.valid_account_name?(account_name)
+      puts Localization.get_message("error.invalid_account_name")
+      exit 1
+    end
+
+    exit_account_not_found(account_name) unless KeyManager.key_exists?(account_name)
+
+    if GitActions.repo_root.nil?
+      puts Localization.get_message("error.not_a_git_repo")
+      exit 1
+    end
+
+    remote_url = GitActions.get_remote_url(remote)
+    if remote_url.nil?
+      puts format(Localization.get_message("error.remote_not_found"), remote: remote)
+      exit 1
+    end
+
+    _account, path = GitActions.parse_github_remote(remote_url)
+    if path.nil?
+      puts format(Localization.get_message("error.not_a_github_remote"), url: remote_url)
+      exit 1
+    end
+
+    new_url = GitActions.account_remote_url(account_name, path)
+    GitActions.set_remote_url(new_url, remote)
+    puts format(Localization.get_message("git.remote_updated"), remote: remote, url: new_url)
+  end
+
+  def detect_account
+    if GitActions.repo_root.nil?
+      puts Localization.get_message("error.not_a_git_repo")
+      exit 1
+    end
+
+    account_name, _path = GitActions.parse_github_remote(GitActions.get_remote_url)
+    if account_name && KeyManager.key_exists?(account_name)
+      puts format(Localization.get_message("git.detected_account"), account: account_name)
+      return
+    elsif account_name
+      puts format(Localization.get_message("git.unknown_account"), account: account_name)
+      exit 1
+    end
+
+    # Without a host alias, the configured email is the best hint.
+    email = GitActions.get_config('user.email')
+    suggestion = email && KeyManager.accounts_with_email(email).first
+    if suggestion
+      puts format(Localization.get_message("git.suggested_account"), account: suggestion, email: email)
+    else
+      puts Localization.get_message("git.no_account_detected")
+      exit 1
+    end
+  end
+
+  # Meant to run from a shell hook, so it stays quiet unless something changes.
+  def autoswitch
+    repo_root = GitActions.repo_root
+    return if repo_root.nil?
+
+    account_name = GitActions.read_repo_account(repo_root)
+    return if account_name.nil?
+
+    unless Validation.valid_account_name?(account_name)
+      puts format(Localization.get_message("error.invalid_repo_account"), file: GitActions::REPO_ACCOUNT_FILE)
+      exit 1
+    end
+
+    exit_account_not_found(account_name) unless KeyManager.key_exists?(account_name)
+
+    changed = false
+    email = KeyManager.public_key_email(account_name)
+    if email && GitActions.get_config('user.email') != email
+      GitActions.set_config('user.email', email)
+      changed = true
+    end
+
+    current_account, path = GitActions.parse_github_remote(GitActions.get_remote_url)
+    if path && current_account != account_name
+      GitActions.set_remote_url(GitActions.account_remote_url(account_name, path))
+      changed = true
+    end
+
+    puts format(Localization.get_message("git.autoswitched"), account: account_name) if changed
+  end
+
+  def test_connections(*args)
+    account_names = args.include?('--all') ? KeyManager.accounts : [args.first || get_account_name]
+    account_names.each do |account_name|
+      unless Validation.valid_account_name?(account_name)
+        puts Localization.get_message("error.invalid_account_name")
+        exit 1
+      end
+
+      exit_account_not_found(account_name) unless KeyManager.key_exists?(account_name)
+    end
+
+    failed = account_names.reject do |account_name|
+      authenticated, message = KeyManager.test_connection(account_name)
+      if authenticated
+        puts format(Localization.get_message("ssh.connection_succeeded"), account: account_name)
+      else
+        puts format(Localization.get_message("error.connection_failed"), account: account_name, message: message)
+      end
+      authenticated
+    end
+
+    exit 1 unless failed.empty?
+  end
+
+  def print_allowed_signers(*args)
+    out_index = args.index('--out')
+    out_path = out_index && args.slice!(out_index, 2)[1]
+
+    lines, skipped = KeyManager.allowed_signers(KeyManager.accounts)
+    skipped.each do |account_name|
+      warn format(Localization.get_message("error.allowed_signer_skipped"), account: account_name).colorize(:color => :yellow)
+    end
+
+    if out_path
+      File.write(File.expand_path(out_path), lines.map { |line| "#{line}\n" }.join)
+      puts format(Localization.get_message("ssh.allowed_signers_written"), path: out_path, count: lines.length)
+    else
+      puts lines
+    end
+  end
+
+  def print_key_path(account_name)
+    unless Validation.valid_account_name?(account_name)
+      puts Localization.get_message("error.invalid_account_name")
+      exit 1
+    end
+
+    exit_account_not_found(account_name) unless KeyManager.key_exists?(account_name)
+
+    puts KeyManager.ssh_key_path(account_name)
+  end
+
+  def change_passphrase(account_name)
+    unless Validation.valid_account_name?(account_name)
+      puts Localization.get_message("error.invalid_account_name")
+      exit 1
+    end
+
+    exit_account_not_found(account_name) unless KeyManager.key_exists?(account_name)
+
+    exit 1 unless KeyManager.change_passphrase(account_name)
+  end
+
+  def list_accounts(*args)
+    format_index = args.index('--format')
+    template = format_index ? args[format_index + 1] : "%{name} <%{email}>"
+    account_details = KeyManager.accounts.map { |account_name| KeyManager.account_details(account_name) }
+
+    begin
+      puts render_accounts(account_details, template)
+    rescue KeyError, ArgumentError
+      puts Localization.get_message("error.invalid_format")
+      exit 1
+    end
+  end
+
+  def render_accounts(account_details, template)
+    raise ArgumentError, "missing format" if template.nil?
+    return JSON.pretty_generate(account_details) if template == 'json'
+
+    account_details.map { |details| format(template, details) }.join("\n")
+  end
+
+  def toggle_account(account_name, enabled)
+    unless Validation.valid_account_name?(account_name)
+      puts Localization.get_message("error.invalid_account_name")
+      exit 1
+    end
+
+    toggled = if enabled
+                KeyManager.enable_ssh_config_entry(account_name)
+              else
+                KeyManager.disable_ssh_config_entry(account_name)
+              end
+    unless toggled
+      puts format(Localization.get_message("error.config_entry_not_found"), account: account_name)
+      exit 1
+    end
+
+    puts format(Localization.get_message(enabled ? "ssh.enabled" : "ssh.disabled"), account: account_name)
+  end
+
+  def preview_ssh_config_entry(account_name)
+    unless Validation.valid_account_name?(account_name)
+      puts Localization.get_message("error.invalid_account_name")
+      exit 1
+    end
+
+    puts KeyManager.render_ssh_config_entry(account_name)
+  end
+
+  def find_accounts(query)
+    if query.nil? || query.empty?
+      puts Localization.get_message("input.search_query")
+      query = STDIN.gets.chomp
+    end
+
+    account_names = KeyManager.find_accounts(query)
+    if account_names.empty?
+      puts Localization.get_message("error.no_matching_accounts")
+      exit 1
+    end
+
+    account_names.each do |account_name|
+      puts "#{account_name} <#{KeyManager.public_key_email(account_name)}>"
+    end
+  end
+
+  def purge(*args)
+    account_names = KeyManager.accounts
+
+    unless args.include?('--force')
+      puts format(Localization.get_message("input.confirm_purge"), count: account_names.length)
+      unless STDIN.gets.chomp == 'y'
+        puts Localization.get_message("system.operation_cancelled")
+        return
+      end
+    end
+
+    _deleted, failed = delete_accounts(account_names)
+    # With every key gone, any managed entry left over is orphaned.
+    KeyManager.prune_orphaned_config_entries(failed)
+
+    if failed.empty?
+      puts Localization.get_message("ssh.purged")
+    else
+      exit 1
+    end
+  end
+
+  def restore_ssh_config
+    unless KeyManager.restore_ssh_config
+      puts format(Localization.get_message("error.backup_not_restored"), path: KeyManager.ssh_config_backup_path)
+      exit 1
+    end
+
+    puts Localization.get_message("ssh.config_restored")
+  end
+
+  def doctor(*args)
+    fix = args.include?('--fix')
+    problems = 0
+
+    orphaned_accounts = KeyManager.config_entry_accounts - KeyManager.accounts
+    problems += orphaned_accounts.length
+    if fix
+      KeyManager.prune_orphaned_config_entries(KeyManager.accounts).each do |account_name|
+        puts format(Localization.get_message("doctor.orphaned_entry_removed"), account: account_name)
+      end
+    else
+      orphaned_accounts.each do |account_name|
+        puts format(Localization.get_message("doctor.orphaned_entry"), account: account_name)
+      end
+    end
+
+    missing_accounts = KeyManager.accounts_missing_config_entry
+    problems += missing_accounts.length
+    missing_accounts.each do |account_name|
+      if fix
+        next unless KeyManager.add_ssh_config_entry(account_name)
+
+        puts format(Localization.get_message("doctor.missing_entry_added"), account: account_name)
+      else
+        puts format(Localization.get_message("doctor.missing_entry"), account: account_name)
+      end
+    end
+
+    duplicate_accounts = KeyManager.duplicate_config_entry_accounts
+    problems += duplicate_accounts.length
+    if fix && !duplicate_accounts.empty?
+      removed = KeyManager.dedupe_config_entries
+      puts format(Localization.get_message("doctor.duplicate_entries_removed"), count: removed)
+    else
+      duplicate_accounts.each do |account_name|
+        puts format(Localization.get_message("doctor.duplicate_entries"), account: account_name)
+      end
+    end
+
+    insecure_accounts = KeyManager.accounts.select do |account_name|
+      KeyManager.key_exists?(account_name) && !KeyManager.secure_key_permissions?(account_name)
+    end
+    problems += insecure_accounts.length
+    insecure_accounts.each do |account_name|
+      if fix
+        KeyManager.fix_key_permissions(account_name)
+        puts format(Localization.get_message("doctor.key_permissions_fixed"), account: account_name)
+      else
+        puts format(Localization.get_message("doctor.insecure_key_permissions"), account: account_name)
+      end
+    end
+
+    if problems.zero?
+      puts Localization.get_message("doctor.no_problems")
+    elsif !fix
+      puts Localization.get_message("doctor.run_fix")
+      exit 1
+    end
+  end
+
+  def show_config(*args)
+    accounts = KeyManager.accounts
+
+    if args.include?('--json')
+      puts JSON.pretty_generate(
+        ssh_dir_path: @config[:ssh_dir_path],
+        ssh_config_path: @config[:ssh_config_path],
+        account_count: accounts.length
+      )
+    else
+      puts format(Localization.get_message("config.ssh_dir_path"), path: @config[:ssh_dir_path])
+      puts format(Localization.get_message("config.ssh_config_path"), path: @config[:ssh_config_path])
+      puts format(Localization.get_message("config.account_count"), count: accounts.length)
+    end
+  end
+
+  # Without a scope, only shows the current one.
+  def set_ssh_scope(scope)
+    unless scope.nil?
+      unless KeyManager::SSH_SCOPES.include?(scope)
+        puts format(Localization.get_message("error.invalid_ssh_scope"), scope: scope)
+        exit 1
+      end
+
+      moved_accounts = KeyManager.switch_ssh_scope(scope)
+      if moved_accounts.nil?
+        puts format(Localization.get_message("error.include_file_still_included"),
+                    path: KeyManager.user_ssh_config_path, include_path: KeyManager.include_file_path)
+        exit 1
+      end
+      unless moved_accounts.empty?
+        puts format(Localization.get_message("ssh.config_entries_moved"),
+                    accounts: moved_accounts.join(', '), path: KeyManager.ssh_config_path)
+      end
+    end
+
+    puts format(Localization.get_message("ssh.current_scope"), scope: KeyManager.ssh_scope, path: KeyManager.ssh_config_path)
   end
 
   def get_account_details
-    account_name = InputManager.get_valid_input("input.account_name", :valid_account_name?)
-    account_email = InputManager.get_valid_input("input.email", :valid_email?)
+    account_name = InputManager.get_valid_input("input.account_name", :valid_account_name?, "error.invalid_account_name")
+    account_email = InputManager.get_valid_input("input.email", :valid_email?, "error.invalid_email")
     [account_name, account_email]
   end
 
   def get_account_name
-    InputManager.get_valid_input("input.account_name", :valid_account_name?)
+    InputManager.get_valid_input("input.account_name", :valid_account_name?, "error.invalid_account_name")
+  end
+
+  def exit_account_not_found(account_name)
+    puts Localization.get_message("error.key_file_not_found")
+    suggestion = KeyManager.suggest_account(account_name)
+    puts format(Localization.get_message("error.did_you_mean"), account: suggestion) if suggestion
+    exit 1
+  end
+
+  def select_account
+    accounts = KeyManager.accounts
+    account_name = InputManager.select_account(accounts)
+    if account_name.nil?
+      puts format(Localization.get_message("error.no_account_selected"), accounts: accounts.join(', '))
+      exit 1
+    end
+    account_name
   end
 
   def load_config
     {
-      ssh_dir_path: "#{ENV['HOME']}/.ssh",
-      ssh_config_path: "#{ENV['HOME']}/.ssh/config",
+      ssh_dir_path: KeyManager.ssh_dir,
+      ssh_config_path: KeyManager.ssh_config_path,
     }
   end
 
   def self.run(args)
+    # https://no-color.org: any non-empty NO_COLOR value disables color.
+    # Piped or redirected output is never colored either.
+    String.disable_colorization = true unless ENV['NO_COLOR'].to_s.empty? && STDOUT.tty?
     options = parse_args(args)
-    multigit = MultiGit.new(options[:command], options[:args])
+    KeyManager.ssh_dir = options[:ssh_dir] if options[:ssh_dir]
+    multigit = MultiGit.new(options[:command], *options[:args])
     multigit.execute
   end
 end
@@ -0,0 +1,55 @@
+# frozen_string_literal: true
+
+require 'minitest/autorun'
+require_relative '../modules/diff'
+
+class DiffTest < Minitest::Test
+  def test_diff_marks_removed_added_and_unchanged_lines
+    assert_equal " a\n-b\n+x\n c", Diff.diff("a\nb\nc\n", "a\nx\nc\n")
+  end
+
+  def test_diff_of_empty_texts
+    assert_equal '', Diff.diff('', '')
+    assert_equal '+a', Diff.diff('', "a\n")
+    assert_equal '-a', Diff.diff("a\n", '')
+  end
+
+  def test_unified_diff_of_identical_texts_is_empty
+    assert_equal '', Diff.unified_diff("a\nb\n", "a\nb\n")
+  end
+
+  def test_unified_diff_keeps_context_around_a_change
+    old_text = (1..10).map { |number| "#{number}\n" }.join
+    new_text = old_text.sub("5\n", "five\n")
+
+    assert_equal "@@ -4,3 +4,3 @@\n 4\n-5\n+five\n 6", Diff.unified_diff(old_text, new_text, 1)
+  end
+
+  def test_unified_diff_splits_distant_changes_into_hunks
+    old_text = (1..10).map { |number| "#{number}\n" }.join
+    new_text = old_text.sub("2\n", "b\n").sub("9\n", "i\n")
+
+    assert_equal "@@ -1,3 +1,3 @@\n 1\n-2\n+b\n 3\n@@ -8,3 +8,3 @@\n 8\n-9\n+i\n 10",
+                 Diff.unified_diff(old_text, new_text, 1)
+  end
+
+  def test_unified_diff_merges_changes_with_touching_context
+    old_text = "1\n2\n3\n4\n"
+    new_text = "one\n2\n3\nfour\n"
+
+    assert_equal "@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n-4\n+four", Diff.unified_diff(old_text, new_text, 1)
+  end
+
+  def test_unified_diff_of_a_new_file
+    assert_equal "@@ -0,0 +1,2 @@\n+a\n+b", Diff.unified_diff('', "a\nb\n")
+  end
+
+  def test_hunk_range_counts_lines_of_one_side
+    assert_equal [2, 2], Diff.hunk_range([' x'], [' y', '-z', '+w'], '+')
+    assert_equal [2, 2], Diff.hunk_range([' x'], [' y', '-z', '+w'], '-')
+  end
+
+  def test_hunk_range_of_an_empty_side_starts_at_the_line_before
+    assert_equal [1, 0], Diff.hunk_range([' x'], ['+y'], '+')
+  end
+end
@@ -0,0 +1,30 @@
+# frozen_string_literal: true
+
+require 'minitest/autorun'
+require_relative '../modules/git_actions'
+
+class GitActionsTest < Minitest::Test
+  def test_parse_github_remote_with_a_host_alias
+    assert_equal ['work', 'owner/repo'], GitActions.parse_github_remote('git@github.com-work:owner/repo.git')
+    assert_equal ['work', 'owner/repo'], GitActions.parse_github_remote('ssh://git@github.com-work/owner/repo.git')
+  end
+
+  def test_parse_github_remote_without_a_host_alias
+    assert_equal [nil, 'owner/repo'], GitActions.parse_github_remote('git@github.com:owner/repo.git')
+    assert_equal [nil, 'owner/repo'], GitActions.parse_github_remote('https://github.com/owner/repo')
+    assert_equal [nil, 'owner/repo'], GitActions.parse_github_remote('https://token@github.com/owner/repo.git/')
+  end
+
+  def test_parse_github_remote_of_other_hosts
+    assert_nil GitActions.parse_github_remote('git@gitlab.com:owner/repo.git')
+    assert_nil GitActions.parse_github_remote('https://github.com.evil.example/owner/repo')
+    assert_nil GitActions.parse_github_remote(nil)
+  end
+
+  def test_account_remote_url_round_trips
+    url = GitActions.account_remote_url('work', 'owner/repo')
+
+    assert_equal 'git@github.com-work:owner/repo.git', url
+    assert_equal ['work', 'owner/repo'], GitActions.parse_github_remote(url)
+  end
+end
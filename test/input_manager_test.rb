@@ -0,0 +1,51 @@
+# frozen_string_literal: true
+
+require 'minitest/autorun'
+require 'stringio'
+require_relative '../modules/input_manager'
+
+class InputManagerTest < Minitest::Test
+  ACCOUNTS = %w[work personal].freeze
+
+  def test_select_account_by_number
+    assert_equal 'personal', select_account("2\n")
+  end
+
+  def test_select_account_asks_again_after_an_invalid_number
+    account_name, out = select_account_with_output("3\nfoo\n1\n")
+
+    assert_equal 'work', account_name
+    assert_equal 2, out.scan(Localization.get_message("error.invalid_selection")).length
+  end
+
+  def test_select_account_can_be_cancelled
+    assert_nil select_account("exit\n")
+    assert_nil select_account('')
+  end
+
+  def test_select_account_without_a_terminal
+    assert_nil InputManager.select_account(ACCOUNTS, StringIO.new("1\n"))
+  end
+
+  def test_select_account_without_accounts
+    assert_nil select_account("1\n", [])
+  end
+
+  private
+
+  def select_account(answers, accounts = ACCOUNTS)
+    select_account_with_output(answers, accounts)[0]
+  end
+
+  # Answers the prompts from a terminal that reads the given lines.
+  def select_account_with_output(answers, accounts = ACCOUNTS)
+    input = StringIO.new(answers)
+    def input.tty?
+      true
+    end
+
+    account_name = nil
+    out, _err = capture_io { account_name = InputManager.select_account(accounts, input) }
+    [account_name, out]
+  end
+end
@@ -0,0 +1,35 @@
+# frozen_string_literal: true
+
+require 'minitest/autorun'
+require 'tempfile'
+require_relative '../modules/key_manager'
+
+class KeyManagerTest < Minitest::Test
+  def test_parse_key_comment_with_a_bare_email
+    assert_equal [nil, 'jane@example.com'], parse_key_comment('jane@example.com')
+  end
+
+  def test_parse_key_comment_with_a_name_and_email
+    assert_equal ['Jane Doe', 'jane@example.com'], parse_key_comment('Jane Doe <jane@example.com>')
+    assert_equal [nil, 'jane@example.com'], parse_key_comment('<jane@example.com>')
+  end
+
+  def test_parse_key_comment_with_freeform_text
+    assert_equal ['laptop key', nil], parse_key_comment('laptop key')
+    assert_equal ['Jane', nil], parse_key_comment('Jane <not an email>')
+  end
+
+  def test_parse_key_comment_without_a_comment
+    assert_equal [nil, nil], parse_key_comment(nil)
+  end
+
+  private
+
+  def parse_key_comment(comment)
+    Tempfile.create('github-work.pub') do |file|
+      file.write(['ssh-ed25519', 'AAAAC3NzaC1lZDI1NTE5AAAAIB', comment].compact.join(' ') + "\n")
+      file.close
+      KeyManager.parse_key_comment(file.path)
+    end
+  end
+end
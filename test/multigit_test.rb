@@ -0,0 +1,31 @@
+# frozen_string_literal: true
+
+require 'minitest/autorun'
+require_relative '../multigit'
+
+class MultiGitTest < Minitest::Test
+  ACCOUNT_DETAILS = [
+    { name: 'work', email: 'jane@work.example', key_path: '/home/jane/.ssh/github-work' },
+    { name: 'personal', email: nil, key_path: '/home/jane/.ssh/github-personal' }
+  ].freeze
+
+  def test_render_accounts_with_a_template
+    assert_equal "work <jane@work.example>\npersonal <>", render_accounts(ACCOUNT_DETAILS, '%{name} <%{email}>')
+    assert_equal '', render_accounts([], '%{name}')
+  end
+
+  def test_render_accounts_as_json
+    assert_equal ACCOUNT_DETAILS, JSON.parse(render_accounts(ACCOUNT_DETAILS, 'json'), symbolize_names: true)
+  end
+
+  def test_render_accounts_with_an_invalid_template
+    assert_raises(KeyError) { render_accounts(ACCOUNT_DETAILS, '%{fingerprint}') }
+    assert_raises(ArgumentError) { render_accounts(ACCOUNT_DETAILS, nil) }
+  end
+
+  private
+
+  def render_accounts(account_details, template)
+    MultiGit.new('list').send(:render_accounts, account_details, template)
+  end
+end
@@ -0,0 +1,154 @@
+# frozen_string_literal: true
+
+require 'minitest/autorun'
+require 'fileutils'
+require 'tmpdir'
+require_relative '../modules/key_manager'
+
+# multigit's SSH config edits must leave everything outside its own entries
+# unchanged. Every test runs against a temporary SSH directory.
+class SSHConfigTest < Minitest::Test
+  ORIGINAL_CONFIGS = {
+    'empty' => '',
+    'no managed entries' => "# Work servers\nHost build\n    HostName build.example.com\n\tUser ci\n",
+    'no final newline' => "Host build\n  User ci",
+    'trailing blank lines' => "Host build\n  User ci\n\n\n",
+    'only comments' => "# nothing here yet\n"
+  }.freeze
+
+  def setup
+    @ssh_dir = Dir.mktmpdir
+    KeyManager.ssh_dir = @ssh_dir
+  end
+
+  def teardown
+    KeyManager.ssh_dir = nil
+    FileUtils.rm_rf(@ssh_dir)
+  end
+
+  def test_add_then_remove_gives_back_the_original
+    ORIGINAL_CONFIGS.each do |name, original|
+      write_config(original)
+      KeyManager.add_ssh_config_entry('work')
+      KeyManager.add_ssh_config_entry('personal', 'Port' => '2222')
+      KeyManager.remove_ssh_config_entry('work')
+      KeyManager.remove_ssh_config_entry('personal')
+
+      assert_equal original, config_content, name
+    end
+  end
+
+  def test_ssh_options_survive_remove_and_add
+    write_config('')
+    KeyManager.add_ssh_config_entry('work', 'Port' => '2222')
+    KeyManager.remove_ssh_config_entry('work')
+    KeyManager.add_ssh_config_entry('work')
+
+    assert_equal KeyManager.render_ssh_config_entry('work', 'Port' => '2222'), config_content
+    assert_equal KeyManager.render_ssh_config_entry('work', 'Port' => '2222'), KeyManager.render_ssh_config_entry('work')
+  end
+
+  def test_remove_keeps_a_comment_after_the_entry
+    write_config("#{entry}# Personal servers\nHost myserver\n")
+    KeyManager.remove_ssh_config_entry('work')
+
+    assert_equal "# Personal servers\nHost myserver\n", config_content
+  end
+
+  def test_disable_then_enable_gives_back_the_original
+    original = "#{entry}# Personal servers\nHost myserver\n"
+    write_config(original)
+
+    KeyManager.disable_ssh_config_entry('work')
+    assert_includes config_content, "\n# Personal servers\nHost myserver\n"
+    KeyManager.enable_ssh_config_entry('work')
+    assert_equal original, config_content
+  end
+
+  def test_remove_a_disabled_entry
+    write_config("#{entry}# Personal servers\nHost myserver\n")
+    KeyManager.disable_ssh_config_entry('work')
+    KeyManager.remove_ssh_config_entry('work')
+
+    assert_equal "# Personal servers\nHost myserver\n", config_content
+  end
+
+  def test_disable_and_enable_an_indented_entry
+    original = "Host build\n\nHost github.com-work\n    HostName github.com\n    User git\n"
+    write_config(original)
+
+    assert KeyManager.disable_ssh_config_entry('work')
+    assert KeyManager.config_entry_disabled?('work')
+    KeyManager.enable_ssh_config_entry('work')
+    assert_equal original, config_content
+  end
+
+  def test_dedupe_keeps_comments_after_a_duplicate_entry
+    write_config("#{entry}# first\n\n#{entry}# second\n")
+    KeyManager.dedupe_config_entries
+
+    assert_equal "# first\n\n#{entry}# second\n", config_content
+  end
+
+  def test_include_scope_moves_entries_and_adds_the_include_once
+    original = "Host build\n  User ci\n\n#{entry}"
+    File.write(KeyManager.user_ssh_config_path, original)
+
+    assert_equal ['work'], KeyManager.switch_ssh_scope('include')
+    assert_equal [], KeyManager.switch_ssh_scope('include')
+    assert_equal 'include', KeyManager.ssh_scope
+    assert_equal entry, File.read(KeyManager.include_file_path)
+    assert_equal "Include #{KeyManager.include_file_path}\nHost build\n  User ci\n",
+                 File.read(KeyManager.user_ssh_config_path)
+    assert_equal ['work'], KeyManager.config_entry_accounts
+  end
+
+  def test_entries_go_to_the_include_file_in_the_include_scope
+    File.write(KeyManager.user_ssh_config_path, "Host build\n  User ci\n")
+    KeyManager.switch_ssh_scope('include')
+    KeyManager.add_ssh_config_entry('work')
+
+    assert_equal entry, File.read(KeyManager.include_file_path)
+    assert_equal "Include #{KeyManager.include_file_path}\nHost build\n  User ci\n",
+                 File.read(KeyManager.user_ssh_config_path)
+  end
+
+  def test_user_scope_moves_entries_back
+    original = "Host build\n  User ci\n\n#{entry}"
+    File.write(KeyManager.user_ssh_config_path, original)
+    KeyManager.switch_ssh_scope('include')
+
+    assert_equal ['work'], KeyManager.switch_ssh_scope('user')
+    assert_equal 'user', KeyManager.ssh_scope
+    assert_equal original, File.read(KeyManager.user_ssh_config_path)
+  end
+
+  def test_user_scope_without_any_config
+    assert_equal [], KeyManager.switch_ssh_scope('user')
+    assert_equal 'user', KeyManager.ssh_scope
+    refute File.exist?(KeyManager.user_ssh_config_path)
+  end
+
+  def test_include_scope_from_a_glob_or_relative_include
+    File.write(KeyManager.user_ssh_config_path, "Include config.d/*\n")
+    assert_equal 'include', KeyManager.ssh_scope
+    assert_nil KeyManager.switch_ssh_scope('user')
+
+    File.write(KeyManager.user_ssh_config_path, "Include config.d/multigit\n")
+    assert_equal 'include', KeyManager.ssh_scope
+  end
+
+  private
+
+  def entry
+    KeyManager.render_ssh_config_entry('work')
+  end
+
+  def write_config(content)
+    File.write(KeyManager.ssh_config_path, content)
+  end
+
+  def config_content
+    File.read(KeyManager.ssh_config_path)
+  end
+end